@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/cyverse-de/configurate"
+	"github.com/cyverse-de/model"
+	"gopkg.in/yaml.v2"
+)
+
+// requiredConfigKeys mirrors the "Required configuration keys" list in the
+// package docstring: the keys every backend needs. config-check fails if
+// any of these are missing or empty in the loaded config.
+var requiredConfigKeys = []string{
+	"amqp.uri",
+	"irods.user",
+	"irods.pass",
+	"irods.host",
+	"irods.port",
+	"irods.base",
+	"irods.resc",
+	"irods.zone",
+	"porklock.image",
+	"porklock.tag",
+}
+
+// condorRequiredConfigKeys are only required when execution.backend is
+// "condor" (the default).
+var condorRequiredConfigKeys = []string{
+	"condor.condor_config",
+	"condor.path_env_var",
+	"condor.log_path",
+	"condor.request_disk",
+}
+
+// kubernetesRequiredConfigKeys are only required when execution.backend
+// is "kubernetes".
+var kubernetesRequiredConfigKeys = []string{
+	"execution.kubernetes_image",
+}
+
+// redactedConfigKeys are printed as "<redacted>" by config-dump instead of
+// their actual values.
+var redactedConfigKeys = []string{
+	"irods.pass",
+	"amqp.uri",
+}
+
+// runConfigCheck implements the "config-check" subcommand: it loads the
+// config file the same way "run" does, verifies every required key for
+// the selected execution.backend is present and non-empty, and - for the
+// condor backend only - verifies that condor_submit and condor_rm can be
+// found on PATH.
+func runConfigCheck(args []string) error {
+	fs := flag.NewFlagSet("config-check", flag.ExitOnError)
+	cfgPath := fs.String("config", "", "Path to the config file. Required.")
+	fs.Parse(args)
+
+	if *cfgPath == "" {
+		fmt.Println("Error: --config must be set.")
+		fs.PrintDefaults()
+		os.Exit(-1)
+	}
+
+	cfg, err := configurate.InitDefaults(*cfgPath, configurate.JobServicesDefaults)
+	if err != nil {
+		return err
+	}
+
+	ok := true
+
+	backend := executionBackend(cfg)
+	keys := append([]string{}, requiredConfigKeys...)
+	switch backend {
+	case "condor":
+		keys = append(keys, condorRequiredConfigKeys...)
+	case "kubernetes":
+		keys = append(keys, kubernetesRequiredConfigKeys...)
+	}
+
+	for _, key := range keys {
+		if cfg.GetString(key) == "" {
+			fmt.Printf("MISSING  %s\n", key)
+			ok = false
+		} else {
+			fmt.Printf("OK       %s\n", key)
+		}
+	}
+
+	if backend == "condor" {
+		for _, binary := range []string{"condor_submit", "condor_rm"} {
+			if path, lookErr := exec.LookPath(binary); lookErr != nil {
+				fmt.Printf("MISSING  %s (%s)\n", binary, lookErr)
+				ok = false
+			} else {
+				fmt.Printf("OK       %s -> %s\n", binary, path)
+			}
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+
+	fmt.Println("config OK")
+	return nil
+}
+
+// runConfigDump implements the "config-dump" subcommand: it prints the
+// effective merged config as YAML, with secret-bearing keys redacted.
+func runConfigDump(args []string) error {
+	fs := flag.NewFlagSet("config-dump", flag.ExitOnError)
+	cfgPath := fs.String("config", "", "Path to the config file. Required.")
+	fs.Parse(args)
+
+	if *cfgPath == "" {
+		fmt.Println("Error: --config must be set.")
+		fs.PrintDefaults()
+		os.Exit(-1)
+	}
+
+	cfg, err := configurate.InitDefaults(*cfgPath, configurate.JobServicesDefaults)
+	if err != nil {
+		return err
+	}
+
+	settings := cfg.AllSettings()
+	for _, key := range redactedConfigKeys {
+		if cfg.GetString(key) != "" {
+			setNestedKey(settings, key, "<redacted>")
+		}
+	}
+
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(out))
+	return nil
+}
+
+// setNestedKey overwrites the value at a viper-style dotted key (e.g.
+// "irods.pass") inside a nested map[string]interface{} tree.
+func setNestedKey(m map[string]interface{}, dottedKey string, value interface{}) {
+	parts := splitDottedKey(dottedKey)
+	cur := m
+	for i, part := range parts {
+		if i == len(parts)-1 {
+			cur[part] = value
+			return
+		}
+		next, ok := cur[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+}
+
+func splitDottedKey(key string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(key); i++ {
+		if key[i] == '.' {
+			parts = append(parts, key[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, key[start:])
+	return parts
+}
+
+// runDryRun implements the "dry-run" subcommand: it reads a model.Job from
+// disk, renders its submission files into a tempdir the same way "run"
+// does, and prints the rendered files to stdout without ever calling
+// condor_submit.
+func runDryRun(args []string) error {
+	fs := flag.NewFlagSet("dry-run", flag.ExitOnError)
+	cfgPath := fs.String("config", "", "Path to the config file. Required.")
+	jobPath := fs.String("job", "", "Path to a model.Job JSON file. Required.")
+	fs.Parse(args)
+
+	if *cfgPath == "" || *jobPath == "" {
+		fmt.Println("Error: --config and --job must both be set.")
+		fs.PrintDefaults()
+		os.Exit(-1)
+	}
+
+	cfg, err := configurate.InitDefaults(*cfgPath, configurate.JobServicesDefaults)
+	if err != nil {
+		return err
+	}
+
+	launcher, err := New(cfg)
+	if err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadFile(*jobPath)
+	if err != nil {
+		return err
+	}
+
+	job := &model.Job{}
+	if err = json.Unmarshal(contents, job); err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "condor-launcher-dry-run-")
+	if err != nil {
+		return err
+	}
+
+	paths, err := launcher.CreateSubmissionFiles(dir, job)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		rendered, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("----- %s -----\n%s\n", p, rendered)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDottedKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{key: "irods.pass", want: []string{"irods", "pass"}},
+		{key: "amqp.uri", want: []string{"amqp", "uri"}},
+		{key: "condor.held_policy.mode", want: []string{"condor", "held_policy", "mode"}},
+		{key: "backend", want: []string{"backend"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := splitDottedKey(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitDottedKey(%q) = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetNestedKey(t *testing.T) {
+	m := map[string]interface{}{
+		"irods": map[string]interface{}{
+			"pass": "secret",
+			"host": "irods.example.org",
+		},
+		"amqp": map[string]interface{}{
+			"uri": "amqp://example.org",
+		},
+	}
+
+	setNestedKey(m, "irods.pass", "<redacted>")
+	setNestedKey(m, "amqp.uri", "<redacted>")
+	setNestedKey(m, "missing.key", "<redacted>")
+
+	irods := m["irods"].(map[string]interface{})
+	if irods["pass"] != "<redacted>" {
+		t.Fatalf("irods.pass = %v, want <redacted>", irods["pass"])
+	}
+	if irods["host"] != "irods.example.org" {
+		t.Fatalf("irods.host = %v, want unchanged", irods["host"])
+	}
+
+	amqp := m["amqp"].(map[string]interface{})
+	if amqp["uri"] != "<redacted>" {
+		t.Fatalf("amqp.uri = %v, want <redacted>", amqp["uri"])
+	}
+
+	if _, ok := m["missing"]; ok {
+		t.Fatalf("setNestedKey should not create missing.key, got %#v", m["missing"])
+	}
+}
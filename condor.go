@@ -1,17 +1,29 @@
 //
 // condor-launcher launches jobs on an HTCondor cluster.
 //
-// This service connects to an AMQP broker's "jobs" exchange and waits for
-// messages sent with the "jobs.launches" key. It then turns the request
-// into an iplant.cmd, config, job, and irods_config file in /tmp/<user>/<UUID>
-// and calls out to condor_submit to submit the job to the cluster.
+// The binary dispatches on its first argument: "run" connects to an AMQP
+// broker's "jobs" exchange and waits for messages sent with the
+// "jobs.launches" key, turning each request into a submission for whichever
+// execution backend is configured and handing it off to that backend to
+// run. "config-check", "config-dump", and "dry-run" validate deployment
+// configuration and render submission templates without touching AMQP or
+// the cluster; see cmd.go.
 //
-// Since it launches jobs by executing the condor_submit command it shouldn't
-// run inside a Docker container. Our Condor cluster is moderately large and
-// requires a lot of ports to be opened up, which doesn't play nicely with
-// Docker.
+// The default backend calls out to condor_submit/condor_rm to run jobs on
+// an HTCondor cluster, turning the request into an iplant.cmd, config,
+// job, and irods-config file in /tmp/<user>/<UUID>. Since it launches jobs
+// by executing the condor_submit command it shouldn't run inside a Docker
+// container. Our Condor cluster is moderately large and requires a lot of
+// ports to be opened up, which doesn't play nicely with Docker. An
+// alternative backend submits the same job description to a Kubernetes
+// cluster as a batch/v1 Job instead; see execution.backend below. That
+// Job runs a single container: road-runner itself, given the same
+// config/job/irods-config files the condor backend writes to its
+// submission directory (mounted from a ConfigMap and a Secret instead),
+// so the actual step arguments, environment, and porklock transfer
+// arguments are still resolved by road-runner, not duplicated here.
 //
-// Required configuration keys are:
+// Required configuration keys, for every backend, are:
 //   amqp.uri
 //   irods.user
 //   irods.pass
@@ -20,28 +32,46 @@
 //   irods.base
 //   irods.resc
 //   irods.zone
+//   porklock.image
+//   porklock.tag
+//
+// Required when execution.backend is "condor" (the default):
 //   condor.condor_config
 //   condor.path_env_var
 //   condor.log_path
 //   condor.request_disk
-//   porklock.image
-//   porklock.tag
+//
+// Required when execution.backend is "kubernetes":
+//   execution.kubernetes_image   image containing the road-runner binary to run as the Job's container
+//
+// Optional configuration keys are:
+//   execution.backend            "condor" (default) or "kubernetes"
+//   execution.kubeconfig         path to a kubeconfig; in-cluster config is used if unset
+//   execution.kubernetes_namespace  namespace Jobs are created in; defaults to "default"
+//   condor.held_policy.mode      "no" (default), "on-failure", or "always"
+//   condor.held_policy.max_retries       release attempts allowed under "on-failure"
+//   condor.held_policy.delay             how long to wait before releasing a held job
+//   condor.held_policy.hold_reason_allowlist  HoldReasonCode ("12") or HoldReasonCode:HoldReasonSubCode ("12:2") entries eligible for release
+//   condor.held_policy.state_file        where release-attempt counts are persisted
+//   porklock.runtime             "docker" (default) or "podman"
+//   porklock.runtime_path        path to the runtime binary, if not on PATH
+//   porklock.rootless            whether road-runner should run porklock rootless
+//   porklock.extra_args          extra arguments passed to the porklock runtime invocation
+//   service.listen                address to serve /healthz, /readyz, and /metrics on; endpoints are disabled if unset
 //
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
-	"text/template"
+	"sync"
 	"time"
 
+	"github.com/cyverse-de/condor-launcher/health"
 	"github.com/cyverse-de/configurate"
 	"github.com/cyverse-de/logcabin"
 	"github.com/cyverse-de/messaging"
@@ -54,131 +84,43 @@ import (
 
 // CondorLauncher contains the condor-launcher application state.
 type CondorLauncher struct {
-	cfg *viper.Viper
+	cfg                 *viper.Viper
+	executor            Executor
+	heldPolicy          *HeldPolicy
+	heldAttempts        *heldJobAttempts
+	heldReleasesPending *pendingReleases
+
+	lastCondorQMu  sync.Mutex
+	lastCondorQAt  time.Time
+	lastCondorQErr error
 }
 
-// New returns a new *CondorLauncher
-func New(c *viper.Viper) *CondorLauncher {
-	return &CondorLauncher{
-		cfg: c,
-	}
-}
-
-// GenerateCondorSubmit returns a string (or error) containing the contents
-// of what should go into an HTCondor submission file.
-func (cl *CondorLauncher) GenerateCondorSubmit(submission *model.Job) (string, error) {
-	tmpl := `universe = vanilla
-executable = /usr/local/bin/road-runner
-rank = mips
-arguments = --config config --job job
-output = script-output.log
-error = script-error.log
-log = condor.log{{if .Group}}
-accounting_group = {{.Group}}
-accounting_group_user = {{.Submitter}}{{end}}
-request_disk = {{.RequestDisk}}
-+IpcUuid = "{{.InvocationID}}"
-+IpcJobId = "generated_script"
-+IpcUsername = "{{.Submitter}}"
-+IpcUserGroups = {{.FormatUserGroups}}
-concurrency_limits = {{.UserIDForSubmission}}
-{{with $x := index .Steps 0}}+IpcExe = "{{$x.Component.Name}}"{{end}}
-{{with $x := index .Steps 0}}+IpcExePath = "{{$x.Component.Location}}"{{end}}
-should_transfer_files = YES
-transfer_input_files = irods-config,iplant.cmd,config,job
-transfer_output_files = logs/de-transfer-trigger.log,logs/logs-stdout-output,logs/logs-stderr-output
-when_to_transfer_output = ON_EXIT_OR_EVICT
-notification = NEVER
-queue
-`
-	t, err := template.New("condor_submit").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-	var buffer bytes.Buffer
-	err = t.Execute(&buffer, submission)
+// New returns a new *CondorLauncher. It selects its Executor based on the
+// execution.backend config key and loads the held-job restart policy from
+// the condor.held_policy config block.
+func New(c *viper.Viper) (*CondorLauncher, error) {
+	executor, err := NewExecutor(c)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return buffer.String(), err
-}
 
-type scriptable struct {
-	model.Job
-	DC []model.VolumesFrom
-	CI []model.ContainerImage
-}
-
-// GenerateJobConfig creates a string containing the config that gets passed
-// into the job.
-func (cl *CondorLauncher) GenerateJobConfig() (string, error) {
-	tmpl := `amqp:
-  uri: {{.GetString "amqp.uri"}}
-irods:
-  base: "{{.GetString "irods.base"}}"
-porklock:
-  image: "{{.GetString "porklock.image"}}"
-  tag: "{{.GetString "porklock.tag"}}"
-condor:
-  filter_files: "{{.GetString "condor.filter_files"}}"`
-	t, err := template.New("job_config").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-	var buffer bytes.Buffer
-	err = t.Execute(&buffer, cl.cfg)
+	heldPolicy, err := LoadHeldPolicy(c)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return buffer.String(), nil
-}
-
-type irodsconfig struct {
-	IRODSHost string
-	IRODSPort string
-	IRODSUser string
-	IRODSPass string
-	IRODSZone string
-	IRODSBase string
-	IRODSResc string
-}
 
-// GenerateIRODSConfig returns the contents of the irods-config file as a string.
-func (cl *CondorLauncher) GenerateIRODSConfig() (string, error) {
-	tmpl := `porklock.irods-host = {{.IRODSHost}}
-porklock.irods-port = {{.IRODSPort}}
-porklock.irods-user = {{.IRODSUser}}
-porklock.irods-pass = {{.IRODSPass}}
-porklock.irods-home = {{.IRODSBase}}
-porklock.irods-zone = {{.IRODSZone}}
-porklock.irods-resc = {{.IRODSResc}}
-`
-	t, err := template.New("irods_config").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-	irodsHost := cl.cfg.GetString("irods.host")
-	irodsPort := cl.cfg.GetString("irods.port")
-	irodsUser := cl.cfg.GetString("irods.user")
-	irodsPass := cl.cfg.GetString("irods.pass")
-	irodsBase := cl.cfg.GetString("irods.base")
-	irodsResc := cl.cfg.GetString("irods.resc")
-	irodsZone := cl.cfg.GetString("irods.zone")
-	c := &irodsconfig{
-		IRODSHost: irodsHost,
-		IRODSPort: irodsPort,
-		IRODSUser: irodsUser,
-		IRODSPass: irodsPass,
-		IRODSBase: irodsBase,
-		IRODSResc: irodsResc,
-		IRODSZone: irodsZone,
-	}
-	var buffer bytes.Buffer
-	err = t.Execute(&buffer, c)
-	if err != nil {
-		return "", err
+	attemptsPath := c.GetString("condor.held_policy.state_file")
+	if attemptsPath == "" {
+		attemptsPath = "/tmp/condor-launcher-held-attempts.json"
 	}
-	return buffer.String(), err
+
+	return &CondorLauncher{
+		cfg:                 c,
+		executor:            executor,
+		heldPolicy:          heldPolicy,
+		heldAttempts:        newHeldJobAttempts(attemptsPath),
+		heldReleasesPending: newPendingReleases(),
+	}, nil
 }
 
 // CreateSubmissionDirectory creates a directory for a submission and returns the path to it as a string.
@@ -194,87 +136,24 @@ func (cl *CondorLauncher) CreateSubmissionDirectory(s *model.Job) (string, error
 	return dirPath, err
 }
 
-// CreateSubmissionFiles creates the iplant.cmd file inside the
-// directory designated by 'dir'. The return values are the path to the iplant.cmd
-// file, and any errors, in that order.
-func (cl *CondorLauncher) CreateSubmissionFiles(dir string, s *model.Job) (string, string, string, error) {
-	cmdContents, err := cl.GenerateCondorSubmit(s)
-	if err != nil {
-		return "", "", "", err
-	}
-
-	jobConfigContents, err := cl.GenerateJobConfig()
-	if err != nil {
-		return "", "", "", err
-	}
-
-	jobContents, err := json.Marshal(s)
-	if err != nil {
-		return "", "", "", err
-	}
-
-	irodsContents, err := cl.GenerateIRODSConfig()
-	if err != nil {
-		return "", "", "", err
-	}
-
-	cmdPath := path.Join(dir, "iplant.cmd")
-	configPath := path.Join(dir, "config")
-	jobPath := path.Join(dir, "job")
-	irodsPath := path.Join(dir, "irods-config")
-
-	err = ioutil.WriteFile(cmdPath, []byte(cmdContents), 0644)
-	if err != nil {
-		return "", "", "", nil
-	}
-
-	err = ioutil.WriteFile(configPath, []byte(jobConfigContents), 0644)
-	if err != nil {
-		return "", "", "", nil
-	}
-
-	err = ioutil.WriteFile(jobPath, []byte(jobContents), 0644)
+// CreateSubmissionFiles asks cl.executor to render the submission for s
+// and writes the result into dir. It returns the list of paths written.
+func (cl *CondorLauncher) CreateSubmissionFiles(dir string, s *model.Job) ([]string, error) {
+	files, err := cl.executor.GenerateSubmission(s)
 	if err != nil {
-		return "", "", "", nil
-	}
-
-	err = ioutil.WriteFile(irodsPath, []byte(irodsContents), 0644)
-
-	return cmdPath, configPath, jobPath, err
-}
-
-func (cl *CondorLauncher) submit(cmdPath string, s *model.Job) (string, error) {
-	csPath, err := exec.LookPath("condor_submit")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if !path.IsAbs(csPath) {
-		csPath, err = filepath.Abs(csPath)
-		if err != nil {
-			return "", err
+	var paths []string
+	for name, contents := range files {
+		p := path.Join(dir, name)
+		if err = ioutil.WriteFile(p, contents, 0644); err != nil {
+			return nil, err
 		}
+		paths = append(paths, p)
 	}
 
-	cmd := exec.Command(csPath, cmdPath)
-	cmd.Dir = path.Dir(cmdPath)
-	pathEnv := cl.cfg.GetString("condor.path_env_var")
-	condorCfg := cl.cfg.GetString("condor.condor_config")
-
-	cmd.Env = []string{
-		fmt.Sprintf("PATH=%s", pathEnv),
-		fmt.Sprintf("CONDOR_CONFIG=%s", condorCfg),
-	}
-
-	output, err := cmd.CombinedOutput()
-	logcabin.Info.Printf("Output of condor_submit:\n%s\n", output)
-	if err != nil {
-		return "", err
-	}
-
-	logcabin.Info.Printf("Extracted ID: %s\n", string(model.ExtractJobID(output)))
-
-	return string(model.ExtractJobID(output)), err
+	return paths, nil
 }
 
 func (cl *CondorLauncher) launch(s *model.Job) (string, error) {
@@ -284,53 +163,25 @@ func (cl *CondorLauncher) launch(s *model.Job) (string, error) {
 		return "", err
 	}
 
-	cmd, _, _, err := cl.CreateSubmissionFiles(sdir, s)
+	_, err = cl.CreateSubmissionFiles(sdir, s)
 	if err != nil {
 		logcabin.Error.Printf("Error creating submission files:\n%s", err)
 		return "", err
 	}
 
-	id, err := cl.submit(cmd, s)
+	id, err := cl.executor.Submit(sdir, s)
 	if err != nil {
 		logcabin.Error.Printf("Error submitting job:\n%s", err)
 		return "", err
 	}
 
-	logcabin.Info.Printf("Condor job id is %s\n", id)
+	logcabin.Info.Printf("Job id is %s\n", id)
 
 	return id, err
 }
 
-func (cl *CondorLauncher) stop(s *model.Job) (string, error) {
-	crPath, err := exec.LookPath("condor_rm")
-	logcabin.Info.Printf("condor_rm found at %s", crPath)
-	if err != nil {
-		return "", err
-	}
-
-	if !path.IsAbs(crPath) {
-		crPath, err = filepath.Abs(crPath)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	pathEnv := cl.cfg.GetString("condor.path_env_var")
-	condorConfig := cl.cfg.GetString("condor.condor_config")
-
-	cmd := exec.Command(crPath, s.CondorID)
-	cmd.Env = []string{
-		fmt.Sprintf("PATH=%s", pathEnv),
-		fmt.Sprintf("CONDOR_CONFIG=%s", condorConfig),
-	}
-
-	output, err := cmd.CombinedOutput()
-	logcabin.Info.Printf("condor_rm output for job %s:\n%s\n", s.CondorID, string(output))
-	if err != nil {
-		return "", err
-	}
-
-	return string(output), err
+func (cl *CondorLauncher) stop(s *model.Job) error {
+	return cl.executor.Stop(s)
 }
 
 // startHeldTicker starts up the code that periodically fires and clean up held
@@ -352,13 +203,52 @@ func (cl *CondorLauncher) startHeldTicker(client *messaging.Client) (*time.Ticke
 	return t, nil
 }
 
+// usage is printed when main is invoked without a recognized subcommand.
+const usage = `Usage: condor-launcher <subcommand> [flags]
+
+Subcommands:
+  run            Connect to AMQP and launch jobs as they arrive (the original daemon behavior).
+  config-check   Validate the config file without launching anything.
+  config-dump    Print the effective merged config as YAML, with secrets redacted.
+  dry-run        Render a job's submission files to stdout without submitting them.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(-1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = runRun(os.Args[2:])
+	case "config-check":
+		err = runConfigCheck(os.Args[2:])
+	case "config-dump":
+		err = runConfigDump(os.Args[2:])
+	case "dry-run":
+		err = runDryRun(os.Args[2:])
+	default:
+		fmt.Printf("Error: unknown subcommand %q\n\n", os.Args[1])
+		fmt.Print(usage)
+		os.Exit(-1)
+	}
+
+	if err != nil {
+		logcabin.Error.Fatal(err)
+	}
+}
+
+// runRun implements the "run" subcommand: the original condor-launcher
+// daemon behavior of connecting to AMQP and launching jobs as they arrive.
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
 	var (
-		cfgPath     = flag.String("config", "", "Path to the config file. Required.")
-		showVersion = flag.Bool("version", false, "Print the version information")
+		cfgPath     = fs.String("config", "", "Path to the config file. Required.")
+		showVersion = fs.Bool("version", false, "Print the version information")
 	)
-
-	flag.Parse()
+	fs.Parse(args)
 
 	logcabin.Init("condor-launcher", "condor-launcher")
 
@@ -369,33 +259,46 @@ func main() {
 
 	if *cfgPath == "" {
 		fmt.Println("Error: --config must be set.")
-		flag.PrintDefaults()
+		fs.PrintDefaults()
 		os.Exit(-1)
 	}
 
 	cfg, err := configurate.InitDefaults(*cfgPath, configurate.JobServicesDefaults)
 	if err != nil {
-		logcabin.Error.Fatal(err)
+		return err
 	}
 	logcabin.Info.Println("Done reading config.")
 
-	launcher := New(cfg)
+	launcher, err := New(cfg)
+	if err != nil {
+		return err
+	}
 
 	uri := cfg.GetString("amqp.uri")
 
 	client, err := messaging.NewClient(uri, true)
 	if err != nil {
-		logcabin.Error.Fatal(err)
+		return err
 	}
 	defer client.Close()
 
 	client.SetupPublishing(messaging.JobsExchange)
 
+	if listenAddr := cfg.GetString("service.listen"); listenAddr != "" {
+		healthSrv := newHealthServer(launcher, client)
+		go func() {
+			if err := healthSrv.ListenAndServe(listenAddr); err != nil {
+				logcabin.Error.Printf("Error serving health/readiness/metrics endpoints:\n%s", err)
+			}
+		}()
+		logcabin.Info.Printf("Serving /healthz, /readyz, and /metrics on %s", listenAddr)
+	}
+
 	go client.Listen()
 
 	ticker, err := launcher.startHeldTicker(client)
 	if err != nil {
-		logcabin.Error.Fatal(err)
+		return err
 	}
 	logcabin.Info.Printf("Started up the held state ticker: %#v", ticker)
 
@@ -422,6 +325,7 @@ func main() {
 		case messaging.Launch:
 			jobID, err := launcher.launch(req.Job)
 			if err != nil {
+				health.LaunchFailures.Inc()
 				logcabin.Error.Print(err)
 				err = client.PublishJobUpdate(&messaging.UpdateMessage{
 					Job:     req.Job,
@@ -432,6 +336,7 @@ func main() {
 					logcabin.Error.Print(err)
 				}
 			} else {
+				health.JobsLaunched.Inc()
 				logcabin.Info.Printf("Launched Condor ID %s", jobID)
 				err = client.PublishJobUpdate(&messaging.UpdateMessage{
 					Job:     req.Job,
@@ -447,4 +352,5 @@ func main() {
 
 	spin := make(chan int)
 	<-spin
+	return nil
 }
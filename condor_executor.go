@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/cyverse-de/condor-launcher/health"
+	"github.com/cyverse-de/logcabin"
+	"github.com/cyverse-de/model"
+	"github.com/spf13/viper"
+)
+
+// CondorExecutor is the original Executor implementation: it renders an
+// HTCondor submission file alongside the job's config/job/irods-config
+// files, and submits/stops jobs with the condor_submit and condor_rm
+// command-line tools.
+type CondorExecutor struct {
+	cfg *viper.Viper
+}
+
+// NewCondorExecutor returns a new *CondorExecutor.
+func NewCondorExecutor(c *viper.Viper) *CondorExecutor {
+	return &CondorExecutor{cfg: c}
+}
+
+// condorSubmitData adds executor-chosen values to the model.Job so they
+// can be referenced from the condor_submit template alongside the job's
+// own fields.
+type condorSubmitData struct {
+	*model.Job
+	ContainerRuntime string
+}
+
+// GenerateCondorSubmit returns a string (or error) containing the contents
+// of what should go into an HTCondor submission file.
+func (ce *CondorExecutor) GenerateCondorSubmit(submission *model.Job) (string, error) {
+	tmpl := `universe = vanilla
+executable = /usr/local/bin/road-runner
+rank = mips
+arguments = --config config --job job
+output = script-output.log
+error = script-error.log
+log = condor.log{{if .Group}}
+accounting_group = {{.Group}}
+accounting_group_user = {{.Submitter}}{{end}}
+request_disk = {{.RequestDisk}}
++IpcUuid = "{{.InvocationID}}"
++IpcJobId = "generated_script"
++IpcUsername = "{{.Submitter}}"
++IpcUserGroups = {{.FormatUserGroups}}
++IpcContainerRuntime = "{{.ContainerRuntime}}"
+concurrency_limits = {{.UserIDForSubmission}}
+{{with $x := index .Steps 0}}+IpcExe = "{{$x.Component.Name}}"{{end}}
+{{with $x := index .Steps 0}}+IpcExePath = "{{$x.Component.Location}}"{{end}}
+should_transfer_files = YES
+transfer_input_files = irods-config,iplant.cmd,config,job
+transfer_output_files = logs/de-transfer-trigger.log,logs/logs-stdout-output,logs/logs-stderr-output
+when_to_transfer_output = ON_EXIT_OR_EVICT
+notification = NEVER
+queue
+`
+	t, err := template.New("condor_submit").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	data := &condorSubmitData{
+		Job:              submission,
+		ContainerRuntime: porklockRuntime(ce.cfg),
+	}
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), err
+}
+
+// GenerateJobConfig creates a string containing the config that gets passed
+// into the job.
+func (ce *CondorExecutor) GenerateJobConfig() (string, error) {
+	return generateJobConfig(ce.cfg)
+}
+
+// GenerateIRODSConfig returns the contents of the irods-config file as a string.
+func (ce *CondorExecutor) GenerateIRODSConfig() (string, error) {
+	return generateIRODSConfig(ce.cfg)
+}
+
+// GenerateSubmission renders the iplant.cmd, config, job, and
+// irods-config files that make up an HTCondor submission.
+func (ce *CondorExecutor) GenerateSubmission(s *model.Job) (map[string][]byte, error) {
+	cmdContents, err := ce.GenerateCondorSubmit(s)
+	if err != nil {
+		return nil, err
+	}
+
+	jobConfigContents, err := ce.GenerateJobConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	jobContents, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	irodsContents, err := ce.GenerateIRODSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"iplant.cmd":   []byte(cmdContents),
+		"config":       []byte(jobConfigContents),
+		"job":          jobContents,
+		"irods-config": []byte(irodsContents),
+	}, nil
+}
+
+// Submit hands the rendered submission in dir off to condor_submit and
+// returns the HTCondor job ID that was assigned to it.
+func (ce *CondorExecutor) Submit(dir string, s *model.Job) (string, error) {
+	csPath, err := exec.LookPath("condor_submit")
+	if err != nil {
+		return "", err
+	}
+
+	if !path.IsAbs(csPath) {
+		csPath, err = filepath.Abs(csPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	cmdPath := path.Join(dir, "iplant.cmd")
+
+	cmd := exec.Command(csPath, cmdPath)
+	cmd.Dir = dir
+	pathEnv := ce.cfg.GetString("condor.path_env_var")
+	condorCfg := ce.cfg.GetString("condor.condor_config")
+
+	cmd.Env = []string{
+		fmt.Sprintf("PATH=%s", pathEnv),
+		fmt.Sprintf("CONDOR_CONFIG=%s", condorCfg),
+	}
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	health.CondorSubmitDuration.Observe(time.Since(start).Seconds())
+	logcabin.Info.Printf("Output of condor_submit:\n%s\n", output)
+	if err != nil {
+		return "", err
+	}
+
+	logcabin.Info.Printf("Extracted ID: %s\n", string(model.ExtractJobID(output)))
+
+	return string(model.ExtractJobID(output)), err
+}
+
+// Stop tells condor_rm to remove the job described by s.
+func (ce *CondorExecutor) Stop(s *model.Job) error {
+	crPath, err := exec.LookPath("condor_rm")
+	logcabin.Info.Printf("condor_rm found at %s", crPath)
+	if err != nil {
+		return err
+	}
+
+	if !path.IsAbs(crPath) {
+		crPath, err = filepath.Abs(crPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	pathEnv := ce.cfg.GetString("condor.path_env_var")
+	condorConfig := ce.cfg.GetString("condor.condor_config")
+
+	cmd := exec.Command(crPath, s.CondorID)
+	cmd.Env = []string{
+		fmt.Sprintf("PATH=%s", pathEnv),
+		fmt.Sprintf("CONDOR_CONFIG=%s", condorConfig),
+	}
+
+	output, err := cmd.CombinedOutput()
+	logcabin.Info.Printf("condor_rm output for job %s:\n%s\n", s.CondorID, string(output))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cyverse-de/model"
+	"github.com/spf13/viper"
+)
+
+// Executor abstracts the scheduler-specific pieces of launching and
+// stopping a job: rendering the submission artifacts, handing them off
+// to the scheduler, and tearing a running job down. CondorLauncher talks
+// to whichever Executor is selected by the execution.backend config key
+// instead of shelling out to condor_submit/condor_rm directly.
+type Executor interface {
+	// GenerateSubmission renders the files that make up a submission,
+	// keyed by the filename they should be written as inside the
+	// submission directory.
+	GenerateSubmission(s *model.Job) (map[string][]byte, error)
+
+	// Submit hands a rendered submission in dir off to the scheduler and
+	// returns the scheduler's identifier for the resulting job.
+	Submit(dir string, s *model.Job) (id string, err error)
+
+	// Stop tells the scheduler to tear down the job described by s.
+	Stop(s *model.Job) error
+}
+
+// executionBackend returns the execution.backend config key, defaulting
+// to "condor" when it's unset. It's shared by NewExecutor and the bits of
+// config-check/readiness that need to know which backend is selected
+// without depending on condor- or kubernetes-only binaries and checks.
+func executionBackend(cfg *viper.Viper) string {
+	backend := cfg.GetString("execution.backend")
+	if backend == "" {
+		backend = "condor"
+	}
+	return backend
+}
+
+// NewExecutor returns the Executor selected by the execution.backend
+// config key. It defaults to "condor" when the key isn't set, which
+// preserves the behavior of earlier versions of condor-launcher.
+func NewExecutor(cfg *viper.Viper) (Executor, error) {
+	switch backend := executionBackend(cfg); backend {
+	case "condor":
+		return NewCondorExecutor(cfg), nil
+	case "kubernetes":
+		return NewKubernetesExecutor(cfg)
+	default:
+		return nil, fmt.Errorf("unknown execution.backend %q, must be 'condor' or 'kubernetes'", backend)
+	}
+}
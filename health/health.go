@@ -0,0 +1,98 @@
+// Package health exposes /healthz, /readyz, and /metrics endpoints for
+// condor-launcher so a Kubernetes or Nomad deployment can gate rollouts
+// and alerts on the service's actual dependencies instead of just whether
+// the process is up.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// CheckFunc probes a single dependency and returns an error if it's
+// unhealthy. It should return quickly; Server does not run checks
+// concurrently with each other.
+type CheckFunc func() error
+
+// CheckResult is the outcome of running a single CheckFunc.
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type namedCheck struct {
+	name string
+	fn   CheckFunc
+}
+
+// Server serves /healthz, /readyz, and /metrics.
+type Server struct {
+	checks []namedCheck
+}
+
+// New returns a *Server with no checks registered. Use AddCheck to
+// register the dependencies /readyz should aggregate.
+func New() *Server {
+	return &Server{}
+}
+
+// AddCheck registers a dependency check to be run on every /readyz
+// request.
+func (s *Server) AddCheck(name string, fn CheckFunc) {
+	s.checks = append(s.checks, namedCheck{name: name, fn: fn})
+}
+
+// Handler returns an http.Handler serving /healthz, /readyz, and /metrics.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// ListenAndServe starts an HTTP server serving Handler() on addr. It
+// blocks until the server exits or returns an error.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// handleHealthz reports liveness: if the process can answer HTTP at all,
+// it's alive. Dependency health belongs in /readyz instead.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// handleReadyz runs every registered check and returns 503 with a JSON
+// body describing each check's status, latency, and error until all of
+// them are green.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]CheckResult, len(s.checks))
+	ready := true
+
+	for _, c := range s.checks {
+		start := time.Now()
+		err := c.fn()
+		result := CheckResult{
+			Status:  "ok",
+			Latency: time.Since(start).String(),
+		}
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+			ready = false
+		}
+		results[c.name] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,47 @@
+package health
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics exported on /metrics. Callers increment/observe these directly
+// from the launcher and held-job ticker rather than going through Server.
+var (
+	JobsLaunched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "condor_launcher",
+		Name:      "jobs_launched_total",
+		Help:      "Number of jobs successfully launched.",
+	})
+
+	LaunchFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "condor_launcher",
+		Name:      "launch_failures_total",
+		Help:      "Number of jobs that failed to launch.",
+	})
+
+	HeldJobsReaped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "condor_launcher",
+		Name:      "held_jobs_reaped_total",
+		Help:      "Number of held jobs removed by the held-job restart policy.",
+	})
+
+	HeldJobsReleased = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "condor_launcher",
+		Name:      "held_jobs_released_total",
+		Help:      "Number of held jobs released by the held-job restart policy.",
+	})
+
+	CondorSubmitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "condor_launcher",
+		Name:      "condor_submit_duration_seconds",
+		Help:      "Time spent running condor_submit.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		JobsLaunched,
+		LaunchFailures,
+		HeldJobsReaped,
+		HeldJobsReleased,
+		CondorSubmitDuration,
+	)
+}
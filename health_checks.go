@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/cyverse-de/condor-launcher/health"
+	"github.com/cyverse-de/messaging"
+)
+
+// condorQMaxAge is how stale the held-job ticker's last condor_q probe can
+// be before /readyz reports it as unhealthy. It's a small multiple of the
+// ticker's 30s interval so a single slow tick doesn't flap readiness.
+const condorQMaxAge = 2 * time.Minute
+
+// newHealthServer builds the health.Server for this launcher, wired up
+// with readiness checks for the things condor-launcher actually depends
+// on: the AMQP connection always, plus the condor_submit/condor_rm
+// binaries and the held-job ticker's cached condor_q probe when the
+// condor backend is selected. A kubernetes-backend deployment has none
+// of those binaries and never runs condor_q, so those checks would
+// never pass and /readyz would never go green; they're only registered
+// for the backend that actually depends on them.
+func newHealthServer(launcher *CondorLauncher, client *messaging.Client) *health.Server {
+	srv := health.New()
+
+	srv.AddCheck("amqp", func() error {
+		if !client.IsConnected() {
+			return fmt.Errorf("not connected to the AMQP broker")
+		}
+		return nil
+	})
+
+	if executionBackend(launcher.cfg) == "condor" {
+		srv.AddCheck("condor_submit", func() error {
+			_, err := exec.LookPath("condor_submit")
+			return err
+		})
+
+		srv.AddCheck("condor_rm", func() error {
+			_, err := exec.LookPath("condor_rm")
+			return err
+		})
+
+		srv.AddCheck("condor_q", func() error {
+			return launcher.CondorQHealthy(condorQMaxAge)
+		})
+	}
+
+	return srv
+}
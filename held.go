@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/condor-launcher/health"
+	"github.com/cyverse-de/logcabin"
+	"github.com/cyverse-de/messaging"
+	"github.com/cyverse-de/model"
+	"github.com/spf13/viper"
+)
+
+// HeldPolicyMode selects what killHeldJobs does with a held job, mirroring
+// the restart-policy modes container runtimes use for exited containers.
+type HeldPolicyMode string
+
+const (
+	// HeldPolicyNo never releases held jobs; they're removed immediately.
+	HeldPolicyNo HeldPolicyMode = "no"
+
+	// HeldPolicyOnFailure releases held jobs whose hold reason is
+	// allowlisted, up to max_retries attempts.
+	HeldPolicyOnFailure HeldPolicyMode = "on-failure"
+
+	// HeldPolicyAlways releases held jobs whose hold reason is
+	// allowlisted with no retry cap.
+	HeldPolicyAlways HeldPolicyMode = "always"
+)
+
+// holdReasonKey identifies a HoldReasonCode/HoldReasonSubCode pair from a
+// condor_q -held record.
+type holdReasonKey struct {
+	Code    int
+	SubCode int
+}
+
+// HeldPolicy is the restart policy applied to held jobs, loaded from the
+// condor.held_policy config block. The allowlist matches on
+// HoldReasonCode/HoldReasonSubCode rather than the free-text HoldReason,
+// since HoldReason embeds slot/host/errno text that's effectively never
+// stable enough to allowlist by exact string match.
+type HeldPolicy struct {
+	Mode            HeldPolicyMode
+	MaxRetries      int
+	Delay           time.Duration
+	AllowedCodes    map[int]bool
+	AllowedSubcodes map[holdReasonKey]bool
+}
+
+// allows reports whether job's hold reason is eligible for release:
+// either its HoldReasonCode is allowlisted outright, or its specific
+// HoldReasonCode/HoldReasonSubCode pair is.
+func (p *HeldPolicy) allows(job heldJob) bool {
+	if p.AllowedCodes[job.HoldReasonCode] {
+		return true
+	}
+	return p.AllowedSubcodes[holdReasonKey{Code: job.HoldReasonCode, SubCode: job.HoldReasonSubCode}]
+}
+
+// LoadHeldPolicy reads the condor.held_policy block out of cfg. A missing
+// condor.held_policy.mode defaults to HeldPolicyNo, which preserves the
+// previous unconditional-reap behavior. Entries in
+// hold_reason_allowlist are either "<HoldReasonCode>", which allowlists
+// that code regardless of subcode, or "<HoldReasonCode>:<HoldReasonSubCode>",
+// which allowlists only that specific pairing.
+func LoadHeldPolicy(cfg *viper.Viper) (*HeldPolicy, error) {
+	mode := HeldPolicyMode(cfg.GetString("condor.held_policy.mode"))
+	if mode == "" {
+		mode = HeldPolicyNo
+	}
+
+	delay := 5 * time.Minute
+	if d := cfg.GetString("condor.held_policy.delay"); d != "" {
+		parsed, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, fmt.Errorf("parsing condor.held_policy.delay: %s", err)
+		}
+		delay = parsed
+	}
+
+	codes := map[int]bool{}
+	subcodes := map[holdReasonKey]bool{}
+	for _, entry := range cfg.GetStringSlice("condor.held_policy.hold_reason_allowlist") {
+		code, subcode, hasSubcode, err := parseHoldReasonEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parsing condor.held_policy.hold_reason_allowlist entry %q: %s", entry, err)
+		}
+		if hasSubcode {
+			subcodes[holdReasonKey{Code: code, SubCode: subcode}] = true
+		} else {
+			codes[code] = true
+		}
+	}
+
+	return &HeldPolicy{
+		Mode:            mode,
+		MaxRetries:      cfg.GetInt("condor.held_policy.max_retries"),
+		Delay:           delay,
+		AllowedCodes:    codes,
+		AllowedSubcodes: subcodes,
+	}, nil
+}
+
+// parseHoldReasonEntry parses a hold_reason_allowlist entry of the form
+// "<code>" or "<code>:<subcode>".
+func parseHoldReasonEntry(entry string) (code int, subcode int, hasSubcode bool, err error) {
+	parts := strings.SplitN(entry, ":", 2)
+
+	code, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid HoldReasonCode: %s", err)
+	}
+
+	if len(parts) == 1 {
+		return code, 0, false, nil
+	}
+
+	subcode, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid HoldReasonSubCode: %s", err)
+	}
+
+	return code, subcode, true, nil
+}
+
+// heldJobAttempts persists, as a flat JSON file keyed by InvocationID, the
+// number of times each job has been released after landing in the held
+// state.
+type heldJobAttempts struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newHeldJobAttempts(path string) *heldJobAttempts {
+	return &heldJobAttempts{path: path}
+}
+
+func (h *heldJobAttempts) load() (map[string]int, error) {
+	counts := map[string]int{}
+
+	contents, err := ioutil.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return counts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(contents, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (h *heldJobAttempts) save(counts map[string]int) error {
+	contents, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(h.path, contents, 0644)
+}
+
+// increment bumps the attempt count for invocationID and returns the new
+// total.
+func (h *heldJobAttempts) increment(invocationID string) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, err := h.load()
+	if err != nil {
+		return 0, err
+	}
+
+	counts[invocationID]++
+	if err = h.save(counts); err != nil {
+		return 0, err
+	}
+
+	return counts[invocationID], nil
+}
+
+// count returns the current attempt count for invocationID without
+// modifying it.
+func (h *heldJobAttempts) count(invocationID string) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, err := h.load()
+	if err != nil {
+		return 0, err
+	}
+	return counts[invocationID], nil
+}
+
+// clear drops the attempt count for invocationID once a job is no longer
+// being retried.
+func (h *heldJobAttempts) clear(invocationID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	delete(counts, invocationID)
+	return h.save(counts)
+}
+
+// prune drops every stored attempt count whose InvocationID isn't in
+// activeInvocationIDs - i.e. jobs that are no longer held, whether
+// because they were reaped (already cleared by removeHeldJob) or because
+// a release let them recover and run to completion, which nothing else
+// ever notices. Called once per killHeldJobs tick so the state file is
+// bounded by the number of currently-held jobs rather than growing for
+// the lifetime of the service.
+func (h *heldJobAttempts) prune(activeInvocationIDs map[string]bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for invocationID := range counts {
+		if !activeInvocationIDs[invocationID] {
+			delete(counts, invocationID)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return h.save(counts)
+}
+
+// pendingReleases tracks held jobs that have an in-flight condor_release
+// scheduled via time.AfterFunc but not yet executed. A job stays in the
+// held state for the whole delay window, so without this, killHeldJobs
+// would see it on every 30s tick and re-schedule (and, previously,
+// re-count) a release that hasn't even fired yet.
+type pendingReleases struct {
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func newPendingReleases() *pendingReleases {
+	return &pendingReleases{pending: map[string]bool{}}
+}
+
+// tryAdd marks invocationID as pending and returns true, or returns false
+// if it was already pending.
+func (p *pendingReleases) tryAdd(invocationID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending[invocationID] {
+		return false
+	}
+	p.pending[invocationID] = true
+	return true
+}
+
+func (p *pendingReleases) remove(invocationID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, invocationID)
+}
+
+// heldJob is the subset of a condor_q -held -json record that the restart
+// policy cares about.
+type heldJob struct {
+	ClusterID         int    `json:"ClusterId"`
+	ProcID            int    `json:"ProcId"`
+	InvocationID      string `json:"IpcUuid"`
+	HoldReason        string `json:"HoldReason"`
+	HoldReasonCode    int    `json:"HoldReasonCode"`
+	HoldReasonSubCode int    `json:"HoldReasonSubCode"`
+}
+
+func (job heldJob) condorID() string {
+	return fmt.Sprintf("%d.%d", job.ClusterID, job.ProcID)
+}
+
+// condorEnv returns the PATH/CONDOR_CONFIG environment condor_q,
+// condor_release, and condor_rm all need to run.
+func (cl *CondorLauncher) condorEnv() []string {
+	return []string{
+		fmt.Sprintf("PATH=%s", cl.cfg.GetString("condor.path_env_var")),
+		fmt.Sprintf("CONDOR_CONFIG=%s", cl.cfg.GetString("condor.condor_config")),
+	}
+}
+
+// condorQHeld lists the jobs currently in the held state, recording the
+// outcome so CondorQHealthy can report on it from /readyz.
+func (cl *CondorLauncher) condorQHeld() ([]heldJob, error) {
+	jobs, err := cl.runCondorQHeld()
+	cl.recordCondorQResult(err)
+	return jobs, err
+}
+
+func (cl *CondorLauncher) runCondorQHeld() ([]heldJob, error) {
+	cqPath, err := exec.LookPath("condor_q")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(cqPath, "-held", "-json")
+	cmd.Env = cl.condorEnv()
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []heldJob
+	if len(bytes.TrimSpace(output)) == 0 {
+		return jobs, nil
+	}
+
+	if err = json.Unmarshal(output, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// recordCondorQResult stashes the outcome of the most recent condor_q
+// probe so CondorQHealthy can be used as a cheap, cached readiness check.
+func (cl *CondorLauncher) recordCondorQResult(err error) {
+	cl.lastCondorQMu.Lock()
+	defer cl.lastCondorQMu.Unlock()
+	cl.lastCondorQAt = time.Now()
+	cl.lastCondorQErr = err
+}
+
+// CondorQHealthy reports whether the most recent condor_q -held probe run
+// by the held-job ticker succeeded within maxAge. It never runs condor_q
+// itself, so it's cheap enough to call from every /readyz request.
+func (cl *CondorLauncher) CondorQHealthy(maxAge time.Duration) error {
+	cl.lastCondorQMu.Lock()
+	defer cl.lastCondorQMu.Unlock()
+
+	if cl.lastCondorQAt.IsZero() {
+		return fmt.Errorf("condor_q has not completed a probe yet")
+	}
+	if cl.lastCondorQErr != nil {
+		return cl.lastCondorQErr
+	}
+	if age := time.Since(cl.lastCondorQAt); age > maxAge {
+		return fmt.Errorf("last successful condor_q probe was %s ago", age)
+	}
+	return nil
+}
+
+// killHeldJobs applies cl.heldPolicy to every currently-held job: jobs
+// whose hold reason is allowlisted and still under max_retries are
+// released via condor_release after the configured delay; everything
+// else is removed with condor_rm and reported as failed. Jobs that
+// already have a release scheduled and waiting out their delay are
+// skipped so they aren't re-counted and re-scheduled on every tick. Any
+// attempt count left over from a job that's no longer held - because a
+// release let it run to completion, rather than landing back in the
+// held state - is pruned so the state file doesn't grow forever.
+func (cl *CondorLauncher) killHeldJobs(client *messaging.Client) {
+	jobs, err := cl.condorQHeld()
+	if err != nil {
+		logcabin.Error.Printf("Error listing held jobs:\n%s", err)
+		return
+	}
+
+	active := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		active[job.InvocationID] = true
+	}
+	if err = cl.heldAttempts.prune(active); err != nil {
+		logcabin.Error.Printf("Error pruning hold attempts:\n%s", err)
+	}
+
+	for _, job := range jobs {
+		if !cl.heldReleasesPending.tryAdd(job.InvocationID) {
+			continue
+		}
+
+		if cl.shouldRelease(job) {
+			cl.scheduleRelease(client, job)
+			continue
+		}
+
+		cl.heldReleasesPending.remove(job.InvocationID)
+		cl.removeHeldJob(client, job)
+	}
+}
+
+// shouldRelease reports whether job should be released rather than
+// removed. It reads, but does not modify, the job's attempt count; the
+// count is only bumped once the release actually fires, in
+// releaseHeldJob.
+func (cl *CondorLauncher) shouldRelease(job heldJob) bool {
+	policy := cl.heldPolicy
+
+	if policy.Mode == HeldPolicyNo || !policy.allows(job) {
+		return false
+	}
+
+	if policy.Mode == HeldPolicyAlways {
+		return true
+	}
+
+	attempts, err := cl.heldAttempts.count(job.InvocationID)
+	if err != nil {
+		logcabin.Error.Printf("Error reading hold attempts for %s:\n%s", job.InvocationID, err)
+		return false
+	}
+
+	return attempts < policy.MaxRetries
+}
+
+// scheduleRelease issues condor_release for job after cl.heldPolicy.Delay
+// without blocking the held-job ticker.
+func (cl *CondorLauncher) scheduleRelease(client *messaging.Client, job heldJob) {
+	logcabin.Info.Printf("Scheduling release of held job %s in %s (hold reason: %s)",
+		job.InvocationID, cl.heldPolicy.Delay, job.HoldReason)
+
+	time.AfterFunc(cl.heldPolicy.Delay, func() {
+		defer cl.heldReleasesPending.remove(job.InvocationID)
+		cl.releaseHeldJob(client, job)
+	})
+}
+
+func (cl *CondorLauncher) releaseHeldJob(client *messaging.Client, job heldJob) {
+	if _, err := cl.heldAttempts.increment(job.InvocationID); err != nil {
+		logcabin.Error.Printf("Error tracking hold attempts for %s:\n%s", job.InvocationID, err)
+	}
+
+	crPath, err := exec.LookPath("condor_release")
+	if err != nil {
+		logcabin.Error.Printf("Error finding condor_release:\n%s", err)
+		return
+	}
+
+	cmd := exec.Command(crPath, job.condorID())
+	cmd.Env = cl.condorEnv()
+
+	output, err := cmd.CombinedOutput()
+	logcabin.Info.Printf("condor_release output for job %s:\n%s\n", job.InvocationID, output)
+	if err != nil {
+		logcabin.Error.Printf("Error releasing job %s:\n%s", job.InvocationID, err)
+		return
+	}
+
+	health.HeldJobsReleased.Inc()
+
+	// messaging.SubmittedState, not a "restarted" state, since this
+	// package already relies on it elsewhere (condor.go's launch-success
+	// path) and a held-job-specific state isn't known to exist in
+	// cyverse-de/messaging.
+	err = client.PublishJobUpdate(&messaging.UpdateMessage{
+		Job:     &model.Job{InvocationID: job.InvocationID},
+		State:   messaging.SubmittedState,
+		Message: fmt.Sprintf("Released held job %s (hold reason: %s)", job.InvocationID, job.HoldReason),
+	})
+	if err != nil {
+		logcabin.Error.Print(err)
+	}
+}
+
+func (cl *CondorLauncher) removeHeldJob(client *messaging.Client, job heldJob) {
+	crPath, err := exec.LookPath("condor_rm")
+	if err != nil {
+		logcabin.Error.Printf("Error finding condor_rm:\n%s", err)
+		return
+	}
+
+	cmd := exec.Command(crPath, job.condorID())
+	cmd.Env = cl.condorEnv()
+
+	output, err := cmd.CombinedOutput()
+	logcabin.Info.Printf("condor_rm output for held job %s:\n%s\n", job.InvocationID, output)
+	if err != nil {
+		logcabin.Error.Printf("Error removing held job %s:\n%s", job.InvocationID, err)
+	}
+
+	if err = cl.heldAttempts.clear(job.InvocationID); err != nil {
+		logcabin.Error.Printf("Error clearing hold attempts for %s:\n%s", job.InvocationID, err)
+	}
+
+	health.HeldJobsReaped.Inc()
+
+	err = client.PublishJobUpdate(&messaging.UpdateMessage{
+		Job:     &model.Job{InvocationID: job.InvocationID},
+		State:   messaging.FailedState,
+		Message: fmt.Sprintf("condor-launcher removed held job %s:\n %s", job.InvocationID, job.HoldReason),
+	})
+	if err != nil {
+		logcabin.Error.Print(err)
+	}
+}
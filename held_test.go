@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseHoldReasonEntry(t *testing.T) {
+	tests := []struct {
+		name           string
+		entry          string
+		wantCode       int
+		wantSubcode    int
+		wantHasSubcode bool
+		wantErr        bool
+	}{
+		{name: "code only", entry: "12", wantCode: 12, wantHasSubcode: false},
+		{name: "code and subcode", entry: "12:2", wantCode: 12, wantSubcode: 2, wantHasSubcode: true},
+		{name: "whitespace is trimmed", entry: " 12 : 2 ", wantCode: 12, wantSubcode: 2, wantHasSubcode: true},
+		{name: "non-numeric code is an error", entry: "abc", wantErr: true},
+		{name: "non-numeric subcode is an error", entry: "12:abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, subcode, hasSubcode, err := parseHoldReasonEntry(tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseHoldReasonEntry(%q): expected an error, got none", tt.entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHoldReasonEntry(%q): unexpected error: %s", tt.entry, err)
+			}
+			if code != tt.wantCode || subcode != tt.wantSubcode || hasSubcode != tt.wantHasSubcode {
+				t.Fatalf("parseHoldReasonEntry(%q) = (%d, %d, %v), want (%d, %d, %v)",
+					tt.entry, code, subcode, hasSubcode, tt.wantCode, tt.wantSubcode, tt.wantHasSubcode)
+			}
+		})
+	}
+}
+
+func TestHeldPolicyAllows(t *testing.T) {
+	v := viper.New()
+	v.Set("condor.held_policy.hold_reason_allowlist", []string{"12", "13:2"})
+
+	policy, err := LoadHeldPolicy(v)
+	if err != nil {
+		t.Fatalf("LoadHeldPolicy: unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		job    heldJob
+		allows bool
+	}{
+		{name: "bare allowlisted code", job: heldJob{HoldReasonCode: 12, HoldReasonSubCode: 99}, allows: true},
+		{name: "allowlisted code:subcode pair", job: heldJob{HoldReasonCode: 13, HoldReasonSubCode: 2}, allows: true},
+		{name: "same code, wrong subcode", job: heldJob{HoldReasonCode: 13, HoldReasonSubCode: 3}, allows: false},
+		{name: "unlisted code", job: heldJob{HoldReasonCode: 1, HoldReasonSubCode: 0}, allows: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.allows(tt.job); got != tt.allows {
+				t.Fatalf("policy.allows(%+v) = %v, want %v", tt.job, got, tt.allows)
+			}
+		})
+	}
+}
+
+func TestHeldJobAttemptsPrune(t *testing.T) {
+	attempts := newHeldJobAttempts(t.TempDir() + "/held-attempts.json")
+
+	if _, err := attempts.increment("kept"); err != nil {
+		t.Fatalf("increment(kept): unexpected error: %s", err)
+	}
+	if _, err := attempts.increment("dropped"); err != nil {
+		t.Fatalf("increment(dropped): unexpected error: %s", err)
+	}
+
+	if err := attempts.prune(map[string]bool{"kept": true}); err != nil {
+		t.Fatalf("prune: unexpected error: %s", err)
+	}
+
+	count, err := attempts.count("kept")
+	if err != nil {
+		t.Fatalf("count(kept): unexpected error: %s", err)
+	}
+	if count != 1 {
+		t.Fatalf("count(kept) = %d, want 1", count)
+	}
+
+	count, err = attempts.count("dropped")
+	if err != nil {
+		t.Fatalf("count(dropped): unexpected error: %s", err)
+	}
+	if count != 0 {
+		t.Fatalf("count(dropped) = %d, want 0 after prune", count)
+	}
+}
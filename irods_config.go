@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+type irodsconfig struct {
+	IRODSHost string
+	IRODSPort string
+	IRODSUser string
+	IRODSPass string
+	IRODSZone string
+	IRODSBase string
+	IRODSResc string
+}
+
+// generateIRODSConfig renders the irods-config file porklock reads to
+// authenticate to iRODS. It's shared by CondorExecutor, which writes it
+// out as a plain file shipped alongside the submission, and
+// KubernetesExecutor, which stores the same contents in a Secret.
+func generateIRODSConfig(cfg *viper.Viper) (string, error) {
+	tmpl := `porklock.irods-host = {{.IRODSHost}}
+porklock.irods-port = {{.IRODSPort}}
+porklock.irods-user = {{.IRODSUser}}
+porklock.irods-pass = {{.IRODSPass}}
+porklock.irods-home = {{.IRODSBase}}
+porklock.irods-zone = {{.IRODSZone}}
+porklock.irods-resc = {{.IRODSResc}}
+`
+	t, err := template.New("irods_config").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	c := &irodsconfig{
+		IRODSHost: cfg.GetString("irods.host"),
+		IRODSPort: cfg.GetString("irods.port"),
+		IRODSUser: cfg.GetString("irods.user"),
+		IRODSPass: cfg.GetString("irods.pass"),
+		IRODSBase: cfg.GetString("irods.base"),
+		IRODSResc: cfg.GetString("irods.resc"),
+		IRODSZone: cfg.GetString("irods.zone"),
+	}
+
+	var buffer bytes.Buffer
+	if err = t.Execute(&buffer, c); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
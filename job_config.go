@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// jobConfigData is the data rendered into the job's config file.
+type jobConfigData struct {
+	AMQPURI             string
+	IRODSBase           string
+	PorklockImage       string
+	PorklockTag         string
+	PorklockRuntime     string
+	PorklockRuntimePath string
+	PorklockRootless    bool
+	PorklockExtraArgs   []string
+	FilterFiles         string
+}
+
+// porklockRuntime returns the configured porklock.runtime, defaulting to
+// "docker" to preserve the previous behavior when it's unset.
+func porklockRuntime(cfg *viper.Viper) string {
+	runtime := cfg.GetString("porklock.runtime")
+	if runtime == "" {
+		runtime = "docker"
+	}
+	return runtime
+}
+
+// generateJobConfig renders the config file road-runner reads alongside
+// the job file. It's shared by CondorExecutor, which writes it out as a
+// plain file transferred alongside the submission, and
+// KubernetesExecutor, which mounts the same contents from a ConfigMap.
+func generateJobConfig(cfg *viper.Viper) (string, error) {
+	tmpl := `amqp:
+  uri: {{.AMQPURI}}
+irods:
+  base: "{{.IRODSBase}}"
+porklock:
+  image: "{{.PorklockImage}}"
+  tag: "{{.PorklockTag}}"
+  runtime: "{{.PorklockRuntime}}"
+  runtime_path: "{{.PorklockRuntimePath}}"
+  rootless: {{.PorklockRootless}}
+  extra_args: [{{range $i, $a := .PorklockExtraArgs}}{{if $i}}, {{end}}"{{$a}}"{{end}}]
+condor:
+  filter_files: "{{.FilterFiles}}"`
+	t, err := template.New("job_config").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	data := &jobConfigData{
+		AMQPURI:             cfg.GetString("amqp.uri"),
+		IRODSBase:           cfg.GetString("irods.base"),
+		PorklockImage:       cfg.GetString("porklock.image"),
+		PorklockTag:         cfg.GetString("porklock.tag"),
+		PorklockRuntime:     porklockRuntime(cfg),
+		PorklockRuntimePath: cfg.GetString("porklock.runtime_path"),
+		PorklockRootless:    cfg.GetBool("porklock.rootless"),
+		PorklockExtraArgs:   cfg.GetStringSlice("porklock.extra_args"),
+		FilterFiles:         cfg.GetString("condor.filter_files"),
+	}
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cyverse-de/logcabin"
+	"github.com/cyverse-de/model"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubernetesExecutor is an Executor that submits DE jobs as Kubernetes
+// batch/v1 Jobs instead of handing them to condor_submit. Rather than
+// re-deriving the tool's command/arguments/environment and porklock's
+// transfer arguments from the model.Job by hand, the Job it generates
+// runs a single container: road-runner itself, the same binary the
+// condor backend executes, given the same config and job files (here
+// mounted from a ConfigMap and a Secret instead of written to a local
+// submission directory). road-runner already owns resolving those
+// details and sequencing the input pull, the tool, and the output push.
+type KubernetesExecutor struct {
+	cfg       *viper.Viper
+	clientset kubernetes.Interface
+	namespace string
+}
+
+// NewKubernetesExecutor returns a new *KubernetesExecutor, building its
+// client-go clientset from the kubeconfig named by execution.kubeconfig,
+// or from in-cluster config when that key is unset.
+func NewKubernetesExecutor(cfg *viper.Viper) (*KubernetesExecutor, error) {
+	var (
+		restCfg *rest.Config
+		err     error
+	)
+
+	kubeconfig := cfg.GetString("execution.kubeconfig")
+	if kubeconfig != "" {
+		restCfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restCfg, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("building kubernetes client config: %s", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("building kubernetes clientset: %s", err)
+	}
+
+	namespace := cfg.GetString("execution.kubernetes_namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	return &KubernetesExecutor{
+		cfg:       cfg,
+		clientset: clientset,
+		namespace: namespace,
+	}, nil
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func (ke *KubernetesExecutor) jobName(s *model.Job) string {
+	return fmt.Sprintf("de-job-%s", s.InvocationID)
+}
+
+func (ke *KubernetesExecutor) configMapName(s *model.Job) string {
+	return fmt.Sprintf("de-job-%s-config", s.InvocationID)
+}
+
+func (ke *KubernetesExecutor) irodsSecretName(s *model.Job) string {
+	return fmt.Sprintf("de-job-%s-irods-config", s.InvocationID)
+}
+
+// GenerateConfigMap builds the ConfigMap carrying the same "config" and
+// "job" file contents the condor backend writes to its submission
+// directory, for road-runner to read out of the mounted volume.
+func (ke *KubernetesExecutor) GenerateConfigMap(s *model.Job) (*corev1.ConfigMap, error) {
+	configContents, err := generateJobConfig(ke.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	jobContents, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ke.configMapName(s),
+			Namespace: ke.namespace,
+			Labels: map[string]string{
+				"app":         "de-job",
+				"external-id": s.InvocationID,
+			},
+		},
+		Data: map[string]string{
+			"config": configContents,
+			"job":    string(jobContents),
+		},
+	}, nil
+}
+
+// GenerateIRODSSecret builds the Secret that carries porklock's
+// irods-config for submission s. It's mounted alongside the config/job
+// files, in the same directory road-runner reads them from.
+func (ke *KubernetesExecutor) GenerateIRODSSecret(s *model.Job) (*corev1.Secret, error) {
+	contents, err := generateIRODSConfig(ke.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ke.irodsSecretName(s),
+			Namespace: ke.namespace,
+			Labels: map[string]string{
+				"app":         "de-job",
+				"external-id": s.InvocationID,
+			},
+		},
+		StringData: map[string]string{
+			"irods-config": contents,
+		},
+	}, nil
+}
+
+// GenerateJobManifest builds the batch/v1 Job that will run submission s.
+func (ke *KubernetesExecutor) GenerateJobManifest(s *model.Job) (*batchv1.Job, error) {
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("job %s has no steps", s.InvocationID)
+	}
+
+	workingDir := "/de-app-work"
+
+	roadRunner := corev1.Container{
+		Name:       "road-runner",
+		Image:      ke.cfg.GetString("execution.kubernetes_image"),
+		Command:    []string{"road-runner"},
+		Args:       []string{"--config", "config", "--job", "job"},
+		WorkingDir: workingDir,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "job-config",
+				MountPath: fmt.Sprintf("%s/config", workingDir),
+				SubPath:   "config",
+				ReadOnly:  true,
+			},
+			{
+				Name:      "job-config",
+				MountPath: fmt.Sprintf("%s/job", workingDir),
+				SubPath:   "job",
+				ReadOnly:  true,
+			},
+			{
+				Name:      "irods-config",
+				MountPath: fmt.Sprintf("%s/irods-config", workingDir),
+				SubPath:   "irods-config",
+				ReadOnly:  true,
+			},
+		},
+	}
+
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ke.jobName(s),
+			Namespace: ke.namespace,
+			Labels: map[string]string{
+				"app":         "de-job",
+				"external-id": s.InvocationID,
+				"username":    s.Submitter,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: int32Ptr(0),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{roadRunner},
+					Volumes: []corev1.Volume{
+						{
+							Name: "job-config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: ke.configMapName(s),
+									},
+								},
+							},
+						},
+						{
+							Name: "irods-config",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: ke.irodsSecretName(s),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return job, nil
+}
+
+// toYAML round-trips v through JSON before handing it to the YAML
+// encoder, so the encoder respects the object's json tags instead of its
+// Go field names.
+func toYAML(v interface{}) ([]byte, error) {
+	asJSON, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	if err = json.Unmarshal(asJSON, &generic); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+// GenerateSubmission renders the Job manifest and its ConfigMap/Secret as
+// YAML, keyed the same way CondorExecutor keys its submission files so
+// CreateSubmissionFiles can stay scheduler-agnostic.
+func (ke *KubernetesExecutor) GenerateSubmission(s *model.Job) (map[string][]byte, error) {
+	job, err := ke.GenerateJobManifest(s)
+	if err != nil {
+		return nil, err
+	}
+	jobYAML, err := toYAML(job)
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, err := ke.GenerateConfigMap(s)
+	if err != nil {
+		return nil, err
+	}
+	configMapYAML, err := toYAML(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ke.GenerateIRODSSecret(s)
+	if err != nil {
+		return nil, err
+	}
+	secretYAML, err := toYAML(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"job.yaml":          jobYAML,
+		"configmap.yaml":    configMapYAML,
+		"irods-secret.yaml": secretYAML,
+	}, nil
+}
+
+// Submit creates the ConfigMap, the irods-config Secret, and the
+// rendered Job in the configured namespace and returns
+// "<namespace>/<name>" as the job's identifier.
+func (ke *KubernetesExecutor) Submit(dir string, s *model.Job) (string, error) {
+	configMap, err := ke.GenerateConfigMap(s)
+	if err != nil {
+		return "", err
+	}
+	if _, err = ke.clientset.CoreV1().ConfigMaps(ke.namespace).Create(configMap); err != nil {
+		return "", fmt.Errorf("creating job config configmap: %s", err)
+	}
+
+	secret, err := ke.GenerateIRODSSecret(s)
+	if err != nil {
+		return "", err
+	}
+	if _, err = ke.clientset.CoreV1().Secrets(ke.namespace).Create(secret); err != nil {
+		return "", fmt.Errorf("creating irods-config secret: %s", err)
+	}
+
+	job, err := ke.GenerateJobManifest(s)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := ke.clientset.BatchV1().Jobs(ke.namespace).Create(job)
+	if err != nil {
+		return "", fmt.Errorf("creating kubernetes job: %s", err)
+	}
+
+	id := fmt.Sprintf("%s/%s", created.Namespace, created.Name)
+	logcabin.Info.Printf("Created kubernetes job %s", id)
+
+	return id, nil
+}
+
+// Stop deletes the Kubernetes Job, ConfigMap, and irods-config Secret
+// associated with s. The Job delete uses a background PropagationPolicy
+// so its pod is torn down along with it instead of being orphaned.
+func (ke *KubernetesExecutor) Stop(s *model.Job) error {
+	propagation := metav1.DeletePropagationBackground
+
+	name := ke.jobName(s)
+	err := ke.clientset.BatchV1().Jobs(ke.namespace).Delete(name, &metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		return fmt.Errorf("deleting kubernetes job %s: %s", name, err)
+	}
+
+	configMapName := ke.configMapName(s)
+	if err = ke.clientset.CoreV1().ConfigMaps(ke.namespace).Delete(configMapName, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("deleting job config configmap %s: %s", configMapName, err)
+	}
+
+	secretName := ke.irodsSecretName(s)
+	if err = ke.clientset.CoreV1().Secrets(ke.namespace).Delete(secretName, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("deleting irods-config secret %s: %s", secretName, err)
+	}
+
+	return nil
+}